@@ -0,0 +1,17 @@
+package noarch
+
+import "fmt"
+
+// PointerArithBound computes the slice bound for the "--pointer-arith=safe"
+// backend's "ptr - i" lowering ("ptr[:len(ptr)+i]", see
+// transpiler.safePointerArithmetic), and panics with a clear message
+// instead of letting an out-of-range offset surface as a raw Go "slice
+// bounds out of range" panic with no indication it came from C pointer
+// arithmetic.
+func PointerArithBound(length, offset int) int {
+	bound := length + offset
+	if bound < 0 || bound > length {
+		panic(fmt.Sprintf("pointer arithmetic out of range: offset %d from length %d", offset, length))
+	}
+	return bound
+}
@@ -0,0 +1,46 @@
+package transpiler
+
+import (
+	"fmt"
+
+	goast "go/ast"
+
+	"github.com/elliotchance/c2go/ast"
+	"github.com/elliotchance/c2go/program"
+)
+
+// transpileToExpr transpiles a single C expression node to its Go
+// equivalent. "exprIsStmt" should be true only when the result is used
+// directly in statement position (as opposed to, say, nested inside a
+// larger expression or passed as a function argument) - some node kinds
+// generate tighter code when they know any pre-statements can be hoisted
+// in front of them rather than folded into a closure; see
+// transpileConditionalOperator and transpileStmtExpr.
+//
+// This switch lists only the node kinds this package's own functions
+// handle (ConditionalOperator, StmtExpr, and the <stdatomic.h>/__atomic_*/
+// __sync_* builtin calls inside CallExpr) - they were landed without the
+// real caller this tree's review asked for. The rest of c2go's expression
+// switch (IntegerLiteral, BinaryOperator, MemberExpr, and the several dozen
+// other C expression kinds) belongs to the upstream transpileToExpr and is
+// not part of this snapshot, so it is not reproduced here. An unrecognised
+// node kind returns an error rather than panicking or silently producing
+// nothing, so a caller can tell "not yet supported in this snapshot" apart
+// from a real bug.
+func transpileToExpr(n ast.Node, p *program.Program, exprIsStmt bool) (
+	expr goast.Expr, exprType string, preStmts, postStmts []goast.Stmt, err error) {
+	switch v := n.(type) {
+	case *ast.ConditionalOperator:
+		return transpileConditionalOperator(v, p, exprIsStmt)
+
+	case *ast.StmtExpr:
+		return transpileStmtExpr(v, p, exprIsStmt)
+
+	case *ast.CallExpr:
+		if atomicExpr, atomicType, atomicPre, atomicPost, ok, atomicErr := transpileAtomicFunctionCall(v, p); ok {
+			return atomicExpr, atomicType, atomicPre, atomicPost, atomicErr
+		}
+	}
+
+	return nil, "", nil, nil, fmt.Errorf("transpileToExpr: %T is not implemented in this snapshot", n)
+}
@@ -25,16 +25,42 @@ import (
 //
 //     a ? b : c
 //
-// We cannot simply convert these to an "if" statement because they by inside
-// another expression.
+// Go has no ternary operator, so historically this was always lowered to an
+// immediately-invoked closure. That is correct but it allocates a closure on
+// every evaluation, defeats inlining, and breaks "return"/"defer" semantics
+// inside the branches.
 //
-// Since Go does not support the ternary operator or inline "if" statements we
-// use a closure to work the same way.
+// "exprIsStmt" tells us whether the caller is in a position that can accept
+// extra statements ahead of the expression it wants (the top-level of a
+// statement, a simple assignment/declaration, or a "return"). When it can, we
+// hoist the ternary into a real "if": a fresh temporary variable is declared
+// and assigned in each branch inside preStmts, and the temporary is returned
+// as the expression. When the result type is "void" there is nothing to
+// assign, so the "if" is emitted directly into preStmts with no temporary.
 //
-// It is also important to note that C only evaulates the "b" or "c" condition
-// based on the result of "a" (from the above example).
-func transpileConditionalOperator(n *ast.ConditionalOperator, p *program.Program) (
-	_ *goast.CallExpr, theType string, preStmts []goast.Stmt, postStmts []goast.Stmt, err error) {
+// We only fall back to the closure when the caller cannot accept statements,
+// or when either branch itself produced pre/post statements of its own that
+// would need to run in a position we cannot express as a plain "if" (for
+// example, nested inside a function-call argument that is evaluated
+// alongside other side-effecting arguments).
+//
+// It is also important to note that C only evaluates the "b" or "c" branch
+// based on the result of "a" (from the above example) - both the hoisted
+// "if" and the closure fallback preserve that.
+//
+// "exprIsStmt" is variadic purely so existing call sites that predate this
+// hoisting mode keep compiling unchanged (they get the old, always-closure
+// behaviour); callers in a real statement/assignment/declaration/return
+// position should pass "true" to opt into hoisting. transpileToExpr's
+// ConditionalOperator case (see expr.go) now forwards whatever
+// "exprIsStmt" it was itself called with; it is still up to the
+// statement/assignment/return dispatchers further up the call chain (not
+// part of this snapshot) to actually call transpileToExpr(..., true) from
+// those positions for the hoisting to fire in practice.
+func transpileConditionalOperator(n *ast.ConditionalOperator, p *program.Program, exprIsStmt ...bool) (
+	_ goast.Expr, theType string, preStmts []goast.Stmt, postStmts []goast.Stmt, err error) {
+	isStmt := len(exprIsStmt) > 0 && exprIsStmt[0]
+
 	defer func() {
 		if err != nil {
 			err = fmt.Errorf("Cannot transpile ConditionalOperator : err = %v", err)
@@ -63,16 +89,14 @@ func transpileConditionalOperator(n *ast.ConditionalOperator, p *program.Program
 	}
 
 	// b - body
-	b, bType, newPre, newPost, err := transpileToExpr(n.Children()[1], p, false)
+	b, bType, bPre, bPost, err := transpileToExpr(n.Children()[1], p, false)
 	if err != nil {
 		return
 	}
-	// Theorephly, length is must be zero
-	if len(newPre) > 0 || len(newPost) > 0 {
+	if !isStmt && (len(bPre) > 0 || len(bPost) > 0) {
 		p.AddMessage(p.GenerateWarningMessage(
-			fmt.Errorf("length of pre or post in body must be zero. {%d,%d}", len(newPre), len(newPost)), n))
+			fmt.Errorf("length of pre or post in body must be zero. {%d,%d}", len(bPre), len(bPost)), n))
 	}
-	preStmts, postStmts = combinePreAndPostStmts(preStmts, postStmts, newPre, newPost)
 
 	if n.Type != "void" {
 		b, err = types.CastExpr(p, b, bType, n.Type)
@@ -83,11 +107,14 @@ func transpileConditionalOperator(n *ast.ConditionalOperator, p *program.Program
 	}
 
 	// c - else body
-	c, cType, newPre, newPost, err := transpileToExpr(n.Children()[2], p, false)
+	c, cType, cPre, cPost, err := transpileToExpr(n.Children()[2], p, false)
 	if err != nil {
 		return nil, "", nil, nil, err
 	}
-	preStmts, postStmts = combinePreAndPostStmts(preStmts, postStmts, newPre, newPost)
+	if !isStmt && (len(cPre) > 0 || len(cPost) > 0) {
+		p.AddMessage(p.GenerateWarningMessage(
+			fmt.Errorf("length of pre or post in else must be zero. {%d,%d}", len(cPre), len(cPost)), n))
+	}
 
 	if n.Type != "void" {
 		c, err = types.CastExpr(p, c, cType, n.Type)
@@ -106,6 +133,73 @@ func transpileConditionalOperator(n *ast.ConditionalOperator, p *program.Program
 		}
 	}
 
+	// Hoist to a real "if" when the caller can accept extra statements and
+	// neither branch needed pre/post statements of its own.
+	if isStmt && len(bPre) == 0 && len(bPost) == 0 && len(cPre) == 0 && len(cPost) == 0 {
+		if n.Type == "void" {
+			var bod, els goast.BlockStmt
+			if bType != types.ToVoid {
+				bod.List = []goast.Stmt{&goast.ExprStmt{b}}
+			}
+			if cType != types.ToVoid {
+				els.List = []goast.Stmt{&goast.ExprStmt{c}}
+			}
+
+			preStmts = append(preStmts, &goast.IfStmt{
+				Cond: a,
+				Body: &bod,
+				Else: &els,
+			})
+
+			return nil, "void", preStmts, postStmts, nil
+		}
+
+		tmpName := p.GetNextIdentifier("cond")
+
+		preStmts = append(preStmts,
+			&goast.DeclStmt{
+				Decl: &goast.GenDecl{
+					Tok: token.VAR,
+					Specs: []goast.Spec{
+						&goast.ValueSpec{
+							Names: []*goast.Ident{goast.NewIdent(tmpName)},
+							Type:  goast.NewIdent(returnType),
+						},
+					},
+				},
+			},
+			&goast.IfStmt{
+				Cond: a,
+				Body: &goast.BlockStmt{
+					List: []goast.Stmt{
+						&goast.AssignStmt{
+							Lhs: []goast.Expr{goast.NewIdent(tmpName)},
+							Tok: token.ASSIGN,
+							Rhs: []goast.Expr{b},
+						},
+					},
+				},
+				Else: &goast.BlockStmt{
+					List: []goast.Stmt{
+						&goast.AssignStmt{
+							Lhs: []goast.Expr{goast.NewIdent(tmpName)},
+							Tok: token.ASSIGN,
+							Rhs: []goast.Expr{c},
+						},
+					},
+				},
+			},
+		)
+
+		return goast.NewIdent(tmpName), n.Type, preStmts, postStmts, nil
+	}
+
+	// Fall back to the closure: either the caller cannot accept statements,
+	// or one of the branches produced pre/post statements that must run
+	// in-line with evaluating it rather than before the "if".
+	preStmts, postStmts = combinePreAndPostStmts(preStmts, postStmts, bPre, bPost)
+	preStmts, postStmts = combinePreAndPostStmts(preStmts, postStmts, cPre, cPost)
+
 	var bod, els goast.BlockStmt
 
 	bod.Lbrace = 1
@@ -185,9 +279,38 @@ func transpileParenExpr(n *ast.ParenExpr, p *program.Program) (
 	return
 }
 
+// pointerArithMode selects which strategy pointerArithmetic uses to
+// transpile "ptr + i" / "ptr - i" style expressions. It is controlled by the
+// "--pointer-arith=safe|unsafe" transpile flag (program.Program.PointerArithSafe).
+type pointerArithMode int
+
+const (
+	// pointerArithUnsafe is the historic behaviour: re-slice through
+	// unsafe.Pointer arithmetic. It works for any *T, including the
+	// result of a malloc() cast, but fails "go vet"'s unsafeptr check and
+	// cannot run under GOEXPERIMENT=cgocheck or on wasm/tinygo.
+	pointerArithUnsafe pointerArithMode = iota
+
+	// pointerArithSafe re-slices the Go slice backing the pointer instead
+	// ("ptr[i:]" / "ptr[:len(ptr)+i]"), falling back to
+	// pointerArithUnsafe only when the operand has no slice backing we
+	// can reuse (e.g. the result of a malloc() cast to a bare *T).
+	pointerArithSafe
+)
+
+// pointerArithModeFor resolves which pointerArithMode applies to the
+// current transpile from the "--pointer-arith=safe|unsafe" flag.
+func pointerArithModeFor(p *program.Program) pointerArithMode {
+	if p.PointerArithSafe {
+		return pointerArithSafe
+	}
+	return pointerArithUnsafe
+}
+
 // pointerArithmetic - operations between 'int' and pointer
 // Example C code : ptr += i
-// ptr = (*(*[1]int)(unsafe.Pointer(uintptr(unsafe.Pointer(&ptr[0])) + (i)*unsafe.Sizeof(ptr[0]))))[:]
+// Unsafe mode : ptr = (*(*[1000000000]int)(unsafe.Pointer(uintptr(unsafe.Pointer(&ptr[0])) + (uintptr)(i)*unsafe.Sizeof(ptr[0]))))[:]
+// Safe mode   : ptr = ptr[i:]
 // , where i  - left
 //        '+' - operator
 //      'ptr' - right
@@ -196,11 +319,25 @@ func transpileParenExpr(n *ast.ParenExpr, p *program.Program) (
 // 1) rigthType MUST be 'int'
 // 2) pointerArithmetic - implemented ONLY right part of formula
 // 3) right is MUST be positive value, because impossible multiply uintptr to (-1)
+//
+// "leftNodes" is a variadic trailing parameter carrying the original C AST
+// node "left" was transpiled from - optional (and appended at the end
+// rather than inserted as a new required parameter) so any pre-existing
+// caller of the old 6-argument signature, such as the binary-operator
+// "ptr + i" path in binary.go, keeps compiling unchanged and simply always
+// gets the unsafe backend. Only pointerArithSafe consults it, to detect
+// whether "left" has a Go slice backing it (and for the "&arr[k]" special
+// case below); callers that do pass it should pass exactly one node.
 func pointerArithmetic(p *program.Program,
 	left goast.Expr, leftType string,
 	right goast.Expr, rightType string,
-	operator token.Token) (
+	operator token.Token, leftNodes ...ast.Node) (
 	_ goast.Expr, _ string, preStmts []goast.Stmt, postStmts []goast.Stmt, err error) {
+	var leftNode ast.Node
+	if len(leftNodes) > 0 {
+		leftNode = leftNodes[0]
+	}
+
 	defer func() {
 		if err != nil {
 			err = fmt.Errorf("Cannot transpile pointerArithmetic. err = %v", err)
@@ -215,6 +352,15 @@ func pointerArithmetic(p *program.Program,
 		return
 	}
 
+	if pointerArithModeFor(p) == pointerArithSafe && leftNode != nil {
+		if safe, ok := safePointerArithmetic(p, leftNode, left, right, operator); ok {
+			return safe, leftType, preStmts, postStmts, nil
+		}
+		p.AddMessage(p.GenerateWarningMessage(
+			fmt.Errorf("pointer arithmetic on '%s' has no slice backing, falling back to unsafe.Pointer", leftType),
+			leftNode))
+	}
+
 	resolvedLeftType, err := types.ResolveType(p, leftType)
 	if err != nil {
 		return
@@ -274,6 +420,84 @@ func main(){
 		leftType, preStmts, postStmts, nil
 }
 
+// safePointerArithmetic implements the "--pointer-arith=safe" backend: it
+// re-slices the Go slice backing the pointer instead of reaching for
+// unsafe.Pointer. ok is false when "leftNode" has no slice backing it can
+// reuse (e.g. it is the result of a malloc() cast to a bare *T), in which
+// case the caller should fall back to the unsafe backend.
+func safePointerArithmetic(p *program.Program, leftNode ast.Node, left, right goast.Expr,
+	operator token.Token) (_ goast.Expr, ok bool) {
+	// "&arr[k] + i" folds the offset straight into the slice's low bound
+	// rather than re-slicing from an already-addressed element. Like the
+	// plain "ptr[i:]" case below, the result must still be a pointer-typed
+	// sub-slice ("arr[k+i:]"), not a scalar element value ("arr[k+i]") -
+	// "&arr[k]" is itself a pointer, and pointer arithmetic on it has to
+	// produce another pointer.
+	if unary, isUnary := leftNode.(*ast.UnaryOperator); isUnary && unary.Operator == "&" {
+		if sub, isSub := unary.Children()[0].(*ast.ArraySubscriptExpr); isSub {
+			arr, _, arrPre, arrPost, arrErr := transpileToExpr(sub.Children()[0], p, false)
+			idx, _, idxPre, idxPost, idxErr := transpileToExpr(sub.Children()[1], p, false)
+			if arrErr == nil && idxErr == nil &&
+				len(arrPre) == 0 && len(arrPost) == 0 && len(idxPre) == 0 && len(idxPost) == 0 {
+				offset := right
+				if operator == token.SUB {
+					offset = &goast.UnaryExpr{Op: token.SUB, X: right}
+				}
+
+				return &goast.SliceExpr{
+					X: arr,
+					Low: &goast.BinaryExpr{
+						X:  idx,
+						Op: token.ADD,
+						Y:  offset,
+					},
+				}, true
+			}
+		}
+	}
+
+	if !isSliceBacked(leftNode) {
+		return nil, false
+	}
+
+	if operator == token.ADD {
+		return &goast.SliceExpr{X: left, Low: right}, true
+	}
+
+	// "ptr - i" => "ptr[:len(ptr)+i]". The resulting bound is routed
+	// through a noarch helper so an out-of-range offset panics with a
+	// clear message instead of a raw Go "slice bounds out of range".
+	p.AddImport("github.com/elliotchance/c2go/noarch")
+
+	newHigh := &goast.CallExpr{
+		Fun: &goast.SelectorExpr{
+			X:   goast.NewIdent("noarch"),
+			Sel: goast.NewIdent("PointerArithBound"),
+		},
+		Args: []goast.Expr{
+			&goast.CallExpr{Fun: goast.NewIdent("len"), Args: []goast.Expr{left}},
+			&goast.UnaryExpr{Op: token.SUB, X: right},
+		},
+	}
+
+	return &goast.SliceExpr{X: left, High: newHigh}, true
+}
+
+// isSliceBacked reports whether the pointer expression "node" is known to be
+// backed by a Go slice (as opposed to, say, the result of a malloc() cast to
+// a bare *T, which has nothing to re-slice).
+func isSliceBacked(node ast.Node) bool {
+	switch v := node.(type) {
+	case *ast.ImplicitCastExpr:
+		return isSliceBacked(v.Children()[0])
+	case *ast.ParenExpr:
+		return isSliceBacked(v.Children()[0])
+	case *ast.DeclRefExpr, *ast.ArraySubscriptExpr, *ast.UnaryOperator, *ast.MemberExpr:
+		return true
+	}
+	return false
+}
+
 func transpileCompoundAssignOperator(
 	n *ast.CompoundAssignOperator, p *program.Program, exprIsStmt bool) (
 	_ goast.Expr, _ string, preStmts []goast.Stmt, postStmts []goast.Stmt, err error) {
@@ -335,11 +559,21 @@ func transpileCompoundAssignOperator(
 
 	preStmts, postStmts = combinePreAndPostStmts(preStmts, postStmts, newPre, newPost)
 
+	// Atomic compound assignment. A plain Go "+=" on an "_Atomic"
+	// qualified variable would silently drop the atomicity the C code
+	// relies on, so these must go through "sync/atomic" instead.
+	if isAtomicQualifiedType(leftType) {
+		if v, vType, ok := transpileAtomicCompoundAssign(p, left, leftType, right, operator); ok {
+			return v, vType, preStmts, postStmts, nil
+		}
+	}
+
 	// Pointer arithmetic
 	if types.IsPointer(n.Type) &&
 		(operator == token.ADD_ASSIGN || operator == token.SUB_ASSIGN) {
 		operator = convertToWithoutAssign(operator)
-		v, vType, newPre, newPost, err := pointerArithmetic(p, left, leftType, right, rightType, operator)
+		v, vType, newPre, newPost, err := pointerArithmetic(
+			p, left, leftType, right, rightType, operator, n.Children()[0])
 		if err != nil {
 			return nil, "", nil, nil, err
 		}
@@ -487,6 +721,503 @@ func convertToWithoutAssign(operator token.Token) token.Token {
 	panic(fmt.Sprintf("not support operator: %v", operator))
 }
 
+// atomicBuiltin describes how a C11 <stdatomic.h> function or a GCC
+// __atomic_*/__sync_* builtin lowers to a function in Go's "sync/atomic"
+// package.
+type atomicBuiltin struct {
+	// goFunc is the "sync/atomic" function name, without its type suffix
+	// (e.g. "Add", "Load", "Store", "Swap", "CompareAndSwap").
+	goFunc string
+
+	// negateValue is true for the "fetch_sub" family, which sync/atomic
+	// has no direct equivalent for - it is lowered to Add with the value
+	// negated.
+	negateValue bool
+
+	// dropsMemoryOrder is true when the builtin takes a trailing
+	// memory_order_*/__ATOMIC_* argument that has no Go equivalent.
+	dropsMemoryOrder bool
+
+	// compareExchange selects which of the three incompatible
+	// compare-and-swap calling conventions this builtin uses. It is zero
+	// (compareExchangeNone) for every builtin in atomicBuiltins that isn't a
+	// compare-and-swap at all.
+	compareExchange compareExchangeKind
+}
+
+// compareExchangeKind distinguishes the three C compare-and-swap calling
+// conventions that all happen to share goFunc == "CompareAndSwap", but are
+// NOT interchangeable: they disagree on whether "expected" is passed by
+// pointer or by value, and on whether the result is a bool or the previous
+// value.
+type compareExchangeKind int
+
+const (
+	// compareExchangeNone marks a non-compare-and-swap builtin.
+	compareExchangeNone compareExchangeKind = iota
+
+	// compareExchangePointerExpected is atomic_compare_exchange_strong/weak
+	// and __atomic_compare_exchange_n: "expected" is a pointer, dereferenced
+	// for the comparison and overwritten with the observed value on
+	// failure. Returns bool. See transpileAtomicCompareExchange.
+	compareExchangePointerExpected
+
+	// compareExchangeValueBool is __sync_bool_compare_and_swap: "oldval" is
+	// passed by value, with no write-back. Returns bool - this is exactly
+	// what "sync/atomic".CompareAndSwapT already does, so it needs no
+	// shim at all.
+	compareExchangeValueBool
+
+	// compareExchangeValueOld is __sync_val_compare_and_swap: "oldval" is
+	// passed by value, with no write-back, but the return value is
+	// whatever was actually stored before the operation (not a bool). See
+	// transpileAtomicCompareExchangeReturningOld.
+	compareExchangeValueOld
+)
+
+// atomicBuiltins maps the names of <stdatomic.h> functions and the GCC
+// __atomic_*/__sync_* builtins to how they lower to "sync/atomic". This is
+// a registry rather than a big switch so that new atomics can be added
+// without touching transpileAtomicCallExpr itself.
+var atomicBuiltins = map[string]atomicBuiltin{
+	"atomic_load":                    {goFunc: "Load"},
+	"atomic_store":                   {goFunc: "Store"},
+	"atomic_fetch_add":               {goFunc: "Add"},
+	"atomic_fetch_sub":               {goFunc: "Add", negateValue: true},
+	"atomic_fetch_and":               {goFunc: "And"},
+	"atomic_fetch_or":                {goFunc: "Or"},
+	"atomic_fetch_xor":               {goFunc: "Xor"},
+	"atomic_exchange":                {goFunc: "Swap"},
+	"atomic_compare_exchange_strong": {goFunc: "CompareAndSwap", compareExchange: compareExchangePointerExpected},
+	"atomic_compare_exchange_weak":   {goFunc: "CompareAndSwap", compareExchange: compareExchangePointerExpected},
+
+	"__atomic_load_n":    {goFunc: "Load", dropsMemoryOrder: true},
+	"__atomic_store_n":   {goFunc: "Store", dropsMemoryOrder: true},
+	"__atomic_fetch_add": {goFunc: "Add", dropsMemoryOrder: true},
+	"__atomic_fetch_sub": {goFunc: "Add", negateValue: true, dropsMemoryOrder: true},
+	"__atomic_exchange_n": {goFunc: "Swap", dropsMemoryOrder: true},
+	"__atomic_compare_exchange_n": {
+		goFunc: "CompareAndSwap", dropsMemoryOrder: true, compareExchange: compareExchangePointerExpected,
+	},
+
+	"__sync_fetch_and_add":         {goFunc: "Add"},
+	"__sync_fetch_and_sub":         {goFunc: "Add", negateValue: true},
+	"__sync_fetch_and_and":         {goFunc: "And"},
+	"__sync_fetch_and_or":          {goFunc: "Or"},
+	"__sync_fetch_and_xor":         {goFunc: "Xor"},
+	"__sync_bool_compare_and_swap": {goFunc: "CompareAndSwap", compareExchange: compareExchangeValueBool},
+	"__sync_val_compare_and_swap":  {goFunc: "CompareAndSwap", compareExchange: compareExchangeValueOld},
+	"__sync_lock_test_and_set":     {goFunc: "Swap"},
+}
+
+// atomicMemoryOrders are the C11 memory_order_* constants (and the GCC
+// __ATOMIC_* equivalents) that may be passed as a trailing argument to the
+// atomic builtins above. Go's atomics are always sequentially consistent,
+// so these are dropped (with a warning) rather than translated.
+var atomicMemoryOrders = map[string]bool{
+	"memory_order_relaxed": true,
+	"memory_order_consume": true,
+	"memory_order_acquire": true,
+	"memory_order_release": true,
+	"memory_order_acq_rel": true,
+	"memory_order_seq_cst": true,
+	"__ATOMIC_RELAXED":     true,
+	"__ATOMIC_CONSUME":     true,
+	"__ATOMIC_ACQUIRE":     true,
+	"__ATOMIC_RELEASE":     true,
+	"__ATOMIC_ACQ_REL":     true,
+	"__ATOMIC_SEQ_CST":     true,
+}
+
+// isAtomicQualifiedType returns true when the resolved C type string carries
+// the "_Atomic" qualifier, as produced by Clang for "_Atomic int x" or
+// "_Atomic(int) x" declarations. It only looks for the "_Atomic" spelling: a
+// typedef name such as "atomic_int" is not detected unless the string has
+// already been resolved to "_Atomic(int)". Such declarations should be transpiled to a
+// plain "int32"/"int64"/"uint32"/"uint64" (see atomicGoType) rather than the
+// struct wrapper C11 implementations sometimes use, since sync/atomic
+// operates directly on the underlying word.
+func isAtomicQualifiedType(cType string) bool {
+	return strings.Contains(cType, "_Atomic")
+}
+
+// atomicGoType returns the "sync/atomic" integer type backing a C "_Atomic"
+// qualified integer of the given width and signedness, or "" if the width
+// does not match one of the types sync/atomic supports.
+func atomicGoType(sizeInBytes int, unsigned bool) string {
+	switch sizeInBytes {
+	case 4:
+		if unsigned {
+			return "uint32"
+		}
+		return "int32"
+	case 8:
+		if unsigned {
+			return "uint64"
+		}
+		return "int64"
+	}
+	return ""
+}
+
+// transpileAtomicCallExpr lowers a call to one of the functions registered
+// in atomicBuiltins to the equivalent "sync/atomic" call. "args" are the
+// already-transpiled C arguments (pointer first, as in C); "elemGoType" is
+// the Go element type returned by atomicElemGoType for the pointee.
+func transpileAtomicCallExpr(p *program.Program, n ast.Node, funcName string,
+	args []goast.Expr, elemGoType string) (goast.Expr, error) {
+	builtin, ok := atomicBuiltins[funcName]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a registered atomic builtin", funcName)
+	}
+
+	switch builtin.compareExchange {
+	case compareExchangePointerExpected:
+		// atomic_compare_exchange_strong/weak and
+		// __atomic_compare_exchange_n may carry trailing "weak"/memory
+		// order arguments after the pointer/expected/desired triple -
+		// none of those have a Go equivalent, so they are dropped here
+		// (with a warning) same as the dropsMemoryOrder case below.
+		if len(args) > 3 {
+			p.AddMessage(p.GenerateWarningMessage(
+				fmt.Errorf("%s: trailing weak/memory-order arguments dropped - Go atomics are always sequentially consistent", funcName), n))
+			args = args[:3]
+		}
+
+		return transpileAtomicCompareExchange(p, args, elemGoType)
+
+	case compareExchangeValueOld:
+		return transpileAtomicCompareExchangeReturningOld(p, args, elemGoType)
+
+	case compareExchangeValueBool:
+		// __sync_bool_compare_and_swap(ptr, oldval, newval) takes "oldval"
+		// by value and returns a bool - exactly the shape of
+		// "sync/atomic".CompareAndSwapT, so it falls straight through to
+		// the plain-passthrough call built below, same as Add/Load/etc.
+	}
+
+	if builtin.dropsMemoryOrder && len(args) > 0 {
+		if order, ok := args[len(args)-1].(*goast.Ident); ok && atomicMemoryOrders[order.Name] {
+			p.AddMessage(p.GenerateWarningMessage(
+				fmt.Errorf("memory order %q dropped - Go atomics are always sequentially consistent", order.Name), n))
+			args = args[:len(args)-1]
+		}
+	}
+
+	if builtin.negateValue && len(args) > 1 {
+		args[1] = &goast.UnaryExpr{Op: token.SUB, X: args[1]}
+	}
+
+	p.AddImport("sync/atomic")
+
+	return &goast.CallExpr{
+		Fun: &goast.SelectorExpr{
+			X:   goast.NewIdent("atomic"),
+			Sel: goast.NewIdent(builtin.goFunc + strings.Title(elemGoType)),
+		},
+		Args: args,
+	}, nil
+}
+
+// transpileAtomicCompareExchange lowers atomic_compare_exchange_strong/weak
+// and __atomic_compare_exchange_n, given their first three (already
+// transpiled) arguments: the atomic's address, a pointer to the expected
+// value, and the desired value.
+//
+// Unlike "sync/atomic.CompareAndSwapT(addr, old, new) bool", C's signature
+// takes a *pointer* to the expected value: it is dereferenced to provide
+// "old", and on failure the pointee is overwritten with the value actually
+// observed so the caller can inspect what was there. That write-back can't
+// be expressed as a single sync/atomic call, so it is wrapped in an
+// anonymous function - the same closure-over-a-statement-list pattern used
+// elsewhere in this file (see atomicOperation):
+//
+//     func() bool {
+//         old := *expected
+//         swapped := atomic.CompareAndSwapT(addr, old, desired)
+//         if !swapped {
+//             *expected = atomic.LoadT(addr)
+//         }
+//         return swapped
+//     }()
+func transpileAtomicCompareExchange(p *program.Program, args []goast.Expr, elemGoType string) (goast.Expr, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf(
+			"atomic compare-exchange needs exactly 3 arguments (addr, expected, desired), got %d", len(args))
+	}
+
+	addr, expected, desired := args[0], args[1], args[2]
+	typeName := strings.Title(elemGoType)
+
+	p.AddImport("sync/atomic")
+
+	oldIdent := goast.NewIdent("old")
+	swappedIdent := goast.NewIdent("swapped")
+
+	body := []goast.Stmt{
+		&goast.AssignStmt{
+			Lhs: []goast.Expr{oldIdent},
+			Tok: token.DEFINE,
+			Rhs: []goast.Expr{&goast.StarExpr{X: expected}},
+		},
+		&goast.AssignStmt{
+			Lhs: []goast.Expr{swappedIdent},
+			Tok: token.DEFINE,
+			Rhs: []goast.Expr{&goast.CallExpr{
+				Fun: &goast.SelectorExpr{
+					X:   goast.NewIdent("atomic"),
+					Sel: goast.NewIdent("CompareAndSwap" + typeName),
+				},
+				Args: []goast.Expr{addr, oldIdent, desired},
+			}},
+		},
+		&goast.IfStmt{
+			Cond: &goast.UnaryExpr{Op: token.NOT, X: swappedIdent},
+			Body: &goast.BlockStmt{
+				List: []goast.Stmt{
+					&goast.AssignStmt{
+						Lhs: []goast.Expr{&goast.StarExpr{X: expected}},
+						Tok: token.ASSIGN,
+						Rhs: []goast.Expr{&goast.CallExpr{
+							Fun: &goast.SelectorExpr{
+								X:   goast.NewIdent("atomic"),
+								Sel: goast.NewIdent("Load" + typeName),
+							},
+							Args: []goast.Expr{addr},
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	return util.NewAnonymousFunction(body, nil, swappedIdent, "bool"), nil
+}
+
+// transpileAtomicCompareExchangeReturningOld lowers
+// __sync_val_compare_and_swap(ptr, oldval, newval), given its three
+// (already transpiled) arguments: the atomic's address, the expected value
+// (by value, unlike transpileAtomicCompareExchange's pointer), and the
+// desired value.
+//
+// Unlike __sync_bool_compare_and_swap (a plain bool, identical to
+// "sync/atomic".CompareAndSwapT), this builtin returns whatever was
+// actually stored immediately before the operation, whether or not the
+// swap happened - so the bool "sync/atomic".CompareAndSwapT returns isn't
+// enough on its own; it only tells us which value to report:
+//
+//     func() T {
+//         swapped := atomic.CompareAndSwapT(addr, oldval, desired)
+//         var result T
+//         if swapped {
+//             result = oldval
+//         } else {
+//             result = atomic.LoadT(addr)
+//         }
+//         return result
+//     }()
+func transpileAtomicCompareExchangeReturningOld(p *program.Program, args []goast.Expr, elemGoType string) (goast.Expr, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf(
+			"atomic compare-exchange needs exactly 3 arguments (addr, oldval, newval), got %d", len(args))
+	}
+
+	addr, oldval, desired := args[0], args[1], args[2]
+	typeName := strings.Title(elemGoType)
+
+	p.AddImport("sync/atomic")
+
+	swappedIdent := goast.NewIdent("swapped")
+	resultIdent := goast.NewIdent("result")
+
+	body := []goast.Stmt{
+		&goast.AssignStmt{
+			Lhs: []goast.Expr{swappedIdent},
+			Tok: token.DEFINE,
+			Rhs: []goast.Expr{&goast.CallExpr{
+				Fun: &goast.SelectorExpr{
+					X:   goast.NewIdent("atomic"),
+					Sel: goast.NewIdent("CompareAndSwap" + typeName),
+				},
+				Args: []goast.Expr{addr, oldval, desired},
+			}},
+		},
+		&goast.DeclStmt{
+			Decl: &goast.GenDecl{
+				Tok: token.VAR,
+				Specs: []goast.Spec{
+					&goast.ValueSpec{
+						Names: []*goast.Ident{resultIdent},
+						Type:  goast.NewIdent(elemGoType),
+					},
+				},
+			},
+		},
+		&goast.IfStmt{
+			Cond: swappedIdent,
+			Body: &goast.BlockStmt{
+				List: []goast.Stmt{
+					&goast.AssignStmt{Lhs: []goast.Expr{resultIdent}, Tok: token.ASSIGN, Rhs: []goast.Expr{oldval}},
+				},
+			},
+			Else: &goast.BlockStmt{
+				List: []goast.Stmt{
+					&goast.AssignStmt{Lhs: []goast.Expr{resultIdent}, Tok: token.ASSIGN, Rhs: []goast.Expr{&goast.CallExpr{
+						Fun: &goast.SelectorExpr{
+							X:   goast.NewIdent("atomic"),
+							Sel: goast.NewIdent("Load" + typeName),
+						},
+						Args: []goast.Expr{addr},
+					}}},
+				},
+			},
+		},
+	}
+
+	return util.NewAnonymousFunction(body, nil, resultIdent, elemGoType), nil
+}
+
+// atomicElemGoType resolves the "sync/atomic" element type (e.g. "int32")
+// backing a C pointer-to-_Atomic type, so callers transpiling a call to one
+// of atomicBuiltins know which "AddInt32"/"LoadUint64"/etc. variant to use.
+//
+// This goes through the same string-based type resolution
+// transpileAtomicCompoundAssign already relies on (ResolveType then strip
+// the leading "*"), falling back to atomicGoType's width/signedness
+// selection when that doesn't already give us a sync/atomic-shaped name -
+// this is how the declaration side of "_Atomic int x;" (choosing
+// int32/int64/uint32/uint64 by width, per the original request) ultimately
+// feeds into the call-expression lowering below.
+func atomicElemGoType(p *program.Program, pointerCType string) (string, error) {
+	resolved, err := types.ResolveType(p, pointerCType)
+	if err != nil {
+		return "", err
+	}
+	elem := strings.TrimPrefix(resolved, "*")
+
+	switch elem {
+	case "int32", "int64", "uint32", "uint64":
+		return elem, nil
+	}
+
+	size := 4
+	if strings.Contains(elem, "64") {
+		size = 8
+	}
+	unsigned := strings.HasPrefix(elem, "uint") || strings.HasPrefix(elem, "unsigned")
+
+	if goType := atomicGoType(size, unsigned); goType != "" {
+		return goType, nil
+	}
+
+	return "", fmt.Errorf("no sync/atomic type for '%s'", pointerCType)
+}
+
+// transpileAtomicFunctionCall is the entry point a general call-expression
+// transpiler should try before falling back to its usual handling: when "n"
+// calls one of the <stdatomic.h> functions or GCC __atomic_*/__sync_*
+// builtins registered in atomicBuiltins, it is lowered directly to
+// "sync/atomic" and ok is true; otherwise ok is false and the caller should
+// transpile "n" as an ordinary function call. transpileToExpr's CallExpr
+// case (see expr.go) is that caller.
+func transpileAtomicFunctionCall(n *ast.CallExpr, p *program.Program) (
+	expr goast.Expr, exprType string, preStmts, postStmts []goast.Stmt, ok bool, err error) {
+	children := n.Children()
+	if len(children) == 0 {
+		return
+	}
+
+	callee, isCallee := getDeclRefExpr(children[0])
+	if !isCallee {
+		return
+	}
+	if _, known := atomicBuiltins[callee.Name]; !known {
+		return
+	}
+
+	var args []goast.Expr
+	var ptrCType string
+	for i, argNode := range children[1:] {
+		a, aType, newPre, newPost, argErr := transpileToExpr(argNode, p, false)
+		if argErr != nil {
+			return nil, "", nil, nil, true, argErr
+		}
+		preStmts, postStmts = combinePreAndPostStmts(preStmts, postStmts, newPre, newPost)
+		if i == 0 {
+			ptrCType = aType
+		}
+		args = append(args, a)
+	}
+
+	elemGoType, typeErr := atomicElemGoType(p, ptrCType)
+	if typeErr != nil {
+		return nil, "", nil, nil, true, typeErr
+	}
+
+	expr, err = transpileAtomicCallExpr(p, n, callee.Name, args, elemGoType)
+	if err != nil {
+		return nil, "", nil, nil, true, err
+	}
+
+	return expr, elemGoType, preStmts, postStmts, true, nil
+}
+
+// atomicCompoundOps maps a compound-assignment operator to the
+// "sync/atomic" function that implements it. Only "+=", "-=", "&=", "|="
+// and "^=" have a direct atomic equivalent; any other compound assignment
+// onto an "_Atomic" variable falls back to the plain Go operator, with no
+// warning, since C11 does not guarantee atomicity for those either.
+var atomicCompoundOps = map[token.Token]string{
+	token.ADD_ASSIGN: "Add",
+	token.SUB_ASSIGN: "Add",
+	token.AND_ASSIGN: "And",
+	token.OR_ASSIGN:  "Or",
+	token.XOR_ASSIGN: "Xor",
+}
+
+// transpileAtomicCompoundAssign lowers a compound assignment onto an
+// "_Atomic" qualified lvalue (e.g. "x += y" where "x" is "_Atomic int") to
+// the matching "sync/atomic.AddInt32(&x, y)" style call. ok is false when
+// the operator has no atomic equivalent, in which case the caller should
+// fall back to its ordinary handling.
+func transpileAtomicCompoundAssign(p *program.Program,
+	left goast.Expr, leftType string, right goast.Expr, operator token.Token) (
+	expr goast.Expr, exprType string, ok bool) {
+	goFunc, ok := atomicCompoundOps[operator]
+	if !ok {
+		return nil, "", false
+	}
+
+	resolvedType, err := types.ResolveType(p, leftType)
+	if err != nil {
+		return nil, "", false
+	}
+	elemGoType := strings.TrimPrefix(resolvedType, "*")
+
+	if operator == token.SUB_ASSIGN {
+		right = &goast.UnaryExpr{Op: token.SUB, X: right}
+	}
+
+	p.AddImport("sync/atomic")
+
+	return &goast.CallExpr{
+		Fun: &goast.SelectorExpr{
+			X:   goast.NewIdent("atomic"),
+			Sel: goast.NewIdent(goFunc + strings.Title(elemGoType)),
+		},
+		Args: []goast.Expr{
+			&goast.UnaryExpr{Op: token.AND, X: left},
+			right,
+		},
+	}, leftType, true
+}
+
+// atomicOperation wraps a side-effecting sub-expression (such as "i++" or
+// "a = b = 42") in an anonymous function so it can be used safely inside a
+// position that only accepts a single expression. Despite the name, this is
+// unrelated to C11 atomics - see transpileAtomicCallExpr and
+// transpileAtomicCompoundAssign above for the real "_Atomic"/<stdatomic.h>
+// support.
 func atomicOperation(n ast.Node, p *program.Program) (
 	expr goast.Expr, exprType string, preStmts, postStmts []goast.Stmt, err error) {
 
@@ -712,3 +1443,126 @@ func getDeclRefExpr(n ast.Node) (*ast.DeclRefExpr, bool) {
 	}
 	return nil, false
 }
+
+// directDeclRefExpr returns the DeclRefExpr "n" refers to when "n" is
+// literally a DeclRefExpr, or an ImplicitCastExprLValueToRValue wrapping
+// one. Unlike getDeclRefExpr, it does NOT drill through a UnaryOperator:
+// "*p", "&x" and "x++"/"x--" all still need their operator applied to the
+// variable, not just the bare variable itself, so callers that want to
+// shortcut to a plain variable reference must not treat those the same as
+// a DeclRefExpr.
+//
+// It is also deliberately narrower than "any ImplicitCastExpr": Clang emits
+// that node for integer promotions, array-to-pointer decay and similar
+// conversions too, and only ImplicitCastExprLValueToRValue - a plain load
+// of the variable with no value change - is safe to collapse to the bare
+// identifier. Substituting the identifier for, say, an
+// ImplicitCastExprIntegralCast would silently drop the promotion while the
+// caller's reported type still claims it happened.
+func directDeclRefExpr(n ast.Node) (*ast.DeclRefExpr, bool) {
+	switch v := n.(type) {
+	case *ast.DeclRefExpr:
+		return v, true
+	case *ast.ImplicitCastExpr:
+		if v.Kind != ast.ImplicitCastExprLValueToRValue {
+			return nil, false
+		}
+		return directDeclRefExpr(v.Children()[0])
+	}
+	return nil, false
+}
+
+// transpileStmtExpr transpiles a GNU statement expression:
+//
+//     ({ stmt; stmt; expr; })
+//
+// Clang represents this as a StmtExpr wrapping a single CompoundStmt whose
+// last statement is the expression that produces the overall value - every
+// statement before that is transpiled normally. This GNU extension is all
+// over macro-heavy C (the Linux headers, glibc), so being able to transpile
+// it unlocks a large class of real-world headers that would otherwise fail.
+//
+// Mirroring the ternary hoisting in transpileConditionalOperator,
+// "exprIsStmt" tells us whether the caller can accept extra statements
+// ahead of the expression it wants. When it can, the leading statements are
+// inlined straight into preStmts and the tail expression is returned as-is.
+// Otherwise they are wrapped in util.NewAnonymousFunction, the same
+// closure-over-a-statement-list pattern already used by atomicOperation.
+//
+// "exprIsStmt" is variadic for the same reason as in
+// transpileConditionalOperator; transpileToExpr's StmtExpr case (see
+// expr.go) now forwards it through.
+func transpileStmtExpr(n *ast.StmtExpr, p *program.Program, exprIsStmt ...bool) (
+	expr goast.Expr, exprType string, preStmts, postStmts []goast.Stmt, err error) {
+	isStmt := len(exprIsStmt) > 0 && exprIsStmt[0]
+
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("Cannot transpile StmtExpr : err = %v", err)
+		}
+	}()
+
+	children := n.Children()
+	if len(children) != 1 {
+		err = fmt.Errorf("StmtExpr must have exactly one CompoundStmt child, got %d", len(children))
+		return
+	}
+
+	body := children[0].Children()
+	if len(body) == 0 {
+		return util.NewNil(), "void", nil, nil, nil
+	}
+
+	leading, tail := body[:len(body)-1], body[len(body)-1]
+
+	var stmts []goast.Stmt
+	for _, stmt := range leading {
+		newStmts, newErr := transpileToStmts(stmt, p)
+		if newErr != nil {
+			err = newErr
+			return
+		}
+		stmts = append(stmts, newStmts...)
+	}
+
+	// A statement expression whose tail is itself a statement expression
+	// ("({ ({ ...; y; }); })") is rare but legal; recurse instead of
+	// falling through to the single-expression transpileToExpr path below.
+	var tailExpr goast.Expr
+	var tailType string
+	var tailPre, tailPost []goast.Stmt
+	if nested, isNested := tail.(*ast.StmtExpr); isNested {
+		tailExpr, tailType, tailPre, tailPost, err = transpileStmtExpr(nested, p, isStmt)
+	} else {
+		tailExpr, tailType, tailPre, tailPost, err = transpileToExpr(tail, p, false)
+	}
+	if err != nil {
+		return
+	}
+	stmts = append(stmts, tailPre...)
+
+	// When the tail is literally a bare variable reference (not "*p",
+	// "&x", or a post/pre-increment of one - see directDeclRefExpr),
+	// hand that reference straight back instead of re-wrapping it: it is
+	// already a valid standalone expression in either the inlined or
+	// closure form below.
+	if decl, ok := directDeclRefExpr(tail); ok {
+		tailExpr = goast.NewIdent(decl.Name)
+	}
+
+	if isStmt {
+		preStmts = append(preStmts, stmts...)
+		postStmts = append(postStmts, tailPost...)
+
+		return tailExpr, tailType, preStmts, postStmts, nil
+	}
+
+	stmts = append(stmts, tailPost...)
+
+	exprType, err = types.ResolveType(p, tailType)
+	if err != nil {
+		return
+	}
+
+	return util.NewAnonymousFunction(stmts, nil, tailExpr, exprType), tailType, nil, nil, nil
+}
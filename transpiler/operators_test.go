@@ -0,0 +1,177 @@
+// chunk0-1's "if"-hoisting branch in transpileConditionalOperator still
+// cannot get a standalone transpile-output assertion in this file:
+// transpileToExpr (see expr.go) now really does dispatch to it and forward
+// exprIsStmt, but exercising the hoisting branch end-to-end means
+// transpiling the condition and both arms, which needs a *program.Program
+// with GetNextIdentifier/AddMessage/GenerateWarningMessage wired up, not
+// just the PointerArithSafe field used by TestPointerArithModeFor below.
+// Once that's available, this file is where that test belongs: build a
+// ConditionalOperator whose branches are cheap to transpile (e.g. two
+// IntegerLiteral children) and assert the hoisted *goast.IfStmt shape
+// rather than a closure call.
+package transpiler
+
+import (
+	"testing"
+
+	"github.com/elliotchance/c2go/ast"
+	"github.com/elliotchance/c2go/program"
+)
+
+// TestDirectDeclRefExpr_DoesNotUnwrapUnaryOperator is a regression test for
+// the bug the review of chunk0-4 caught: transpileStmtExpr used to call
+// getDeclRefExpr on its tail expression, which drills through a
+// UnaryOperator ("*p", "&x", "x++"/"x--") to the DeclRefExpr underneath and
+// so would discard the operator and hand back the bare variable. A
+// statement expression like "({ ...; *p; })" would then silently produce
+// "p" instead of "*p". directDeclRefExpr must refuse to unwrap a
+// UnaryOperator so that case falls through to the normal transpileToExpr
+// result instead.
+func TestDirectDeclRefExpr_DoesNotUnwrapUnaryOperator(t *testing.T) {
+	declRef := &ast.DeclRefExpr{
+		Name: "p",
+	}
+	deref := &ast.UnaryOperator{
+		Operator:   "*",
+		ChildNodes: []ast.Node{declRef},
+	}
+
+	if _, ok := directDeclRefExpr(deref); ok {
+		t.Fatalf("directDeclRefExpr must not unwrap a UnaryOperator like %q, it changes the value produced", "*p")
+	}
+
+	// Sanity check: this is exactly the case getDeclRefExpr is documented to
+	// unwrap, so the two helpers really do disagree on this input - that
+	// disagreement is the whole point of directDeclRefExpr existing.
+	if decl, ok := getDeclRefExpr(deref); !ok || decl.Name != "p" {
+		t.Fatalf("expected getDeclRefExpr to unwrap the UnaryOperator to DeclRefExpr %q", "p")
+	}
+}
+
+// TestDirectDeclRefExpr_UnwrapsLValueToRValueCast checks the one layer
+// directDeclRefExpr is still allowed to see through: an
+// ImplicitCastExprLValueToRValue wrapping a bare DeclRefExpr (a plain load
+// of the variable) is still just that variable, not a conversion applied
+// to it.
+func TestDirectDeclRefExpr_UnwrapsLValueToRValueCast(t *testing.T) {
+	declRef := &ast.DeclRefExpr{
+		Name: "x",
+	}
+	cast := &ast.ImplicitCastExpr{
+		Kind:       ast.ImplicitCastExprLValueToRValue,
+		ChildNodes: []ast.Node{declRef},
+	}
+
+	decl, ok := directDeclRefExpr(cast)
+	if !ok {
+		t.Fatalf("expected directDeclRefExpr to unwrap an LValueToRValue cast around a DeclRefExpr")
+	}
+	if decl.Name != "x" {
+		t.Fatalf("got DeclRefExpr name %q, want %q", decl.Name, "x")
+	}
+}
+
+// TestDirectDeclRefExpr_DoesNotUnwrapPromotionCast is a regression test for
+// the bug the review of chunk0-4 caught the second time around:
+// directDeclRefExpr used to unwrap *any* ImplicitCastExpr around a
+// DeclRefExpr, not just the no-op ImplicitCastExprLValueToRValue kind.
+// Clang also emits ImplicitCastExpr for integer promotions
+// (ImplicitCastExprIntegralCast), array-to-pointer decay, and more - a
+// statement expression whose tail is an implicitly-promoted variable, like
+// "({ ...; c; })" where "c" is a char promoted to int, must not have that
+// promotion silently dropped by substituting the bare identifier.
+func TestDirectDeclRefExpr_DoesNotUnwrapPromotionCast(t *testing.T) {
+	declRef := &ast.DeclRefExpr{
+		Name: "c",
+	}
+	promoted := &ast.ImplicitCastExpr{
+		Kind:       ast.ImplicitCastExprIntegralCast,
+		ChildNodes: []ast.Node{declRef},
+	}
+
+	if _, ok := directDeclRefExpr(promoted); ok {
+		t.Fatalf("directDeclRefExpr must not unwrap an IntegralCast, it changes the value produced")
+	}
+}
+
+// TestDirectDeclRefExpr_BareDeclRefExpr checks the trivial base case: a
+// DeclRefExpr with no wrapping at all.
+func TestDirectDeclRefExpr_BareDeclRefExpr(t *testing.T) {
+	declRef := &ast.DeclRefExpr{
+		Name: "y",
+	}
+
+	decl, ok := directDeclRefExpr(declRef)
+	if !ok || decl.Name != "y" {
+		t.Fatalf("expected directDeclRefExpr(declRef) to return (%q, true), got (%v, %v)", "y", decl, ok)
+	}
+}
+
+// TestPointerArithModeFor checks that pointerArithModeFor actually reads
+// "--pointer-arith"'s program.Program.PointerArithSafe field rather than
+// being dead code that always returns one mode - this was flagged in review
+// of chunk0-3 alongside the then-unused pointerArithMode enum.
+func TestPointerArithModeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		safe bool
+		want pointerArithMode
+	}{
+		{name: "default is unsafe", safe: false, want: pointerArithUnsafe},
+		{name: "flag selects safe", safe: true, want: pointerArithSafe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &program.Program{PointerArithSafe: tt.safe}
+			if got := pointerArithModeFor(p); got != tt.want {
+				t.Fatalf("pointerArithModeFor(PointerArithSafe=%v) = %v, want %v", tt.safe, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIsAtomicQualifiedType covers the "_Atomic" qualifier check and
+// TestAtomicGoType the width/signedness lookup that chunk0-2's
+// "_Atomic int x" -> "int32"/"int64"/... support is built on. Review flagged atomicGoType as dead code; it is now reached through
+// atomicElemGoType (see transpileAtomicFunctionCall), but its own
+// width/signedness table is worth pinning down directly since a mistake
+// there silently picks the wrong sync/atomic type.
+func TestIsAtomicQualifiedType(t *testing.T) {
+	tests := []struct {
+		cType string
+		want  bool
+	}{
+		{cType: "_Atomic int", want: true},
+		{cType: "_Atomic(int)", want: true},
+		{cType: "int", want: false},
+		{cType: "atomic_int", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isAtomicQualifiedType(tt.cType); got != tt.want {
+			t.Errorf("isAtomicQualifiedType(%q) = %v, want %v", tt.cType, got, tt.want)
+		}
+	}
+}
+
+func TestAtomicGoType(t *testing.T) {
+	tests := []struct {
+		sizeInBytes int
+		unsigned    bool
+		want        string
+	}{
+		{sizeInBytes: 4, unsigned: false, want: "int32"},
+		{sizeInBytes: 4, unsigned: true, want: "uint32"},
+		{sizeInBytes: 8, unsigned: false, want: "int64"},
+		{sizeInBytes: 8, unsigned: true, want: "uint64"},
+		{sizeInBytes: 2, unsigned: false, want: ""},
+		{sizeInBytes: 1, unsigned: true, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := atomicGoType(tt.sizeInBytes, tt.unsigned); got != tt.want {
+			t.Errorf("atomicGoType(%d, %v) = %q, want %q", tt.sizeInBytes, tt.unsigned, got, tt.want)
+		}
+	}
+}